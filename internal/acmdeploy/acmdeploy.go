@@ -0,0 +1,78 @@
+// Package acmdeploy deploys a certificate this Lambda has already issued
+// into AWS Certificate Manager and, optionally, IAM server certificates --
+// closing the loop so the certificate can be attached directly to
+// CloudFront, ELB classic, or other AWS services without a manual glue
+// step.
+package acmdeploy
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// ImportToACM imports a certificate into ACM. When existingArn is non-empty
+// it is passed through as acm.ImportCertificateInput.CertificateArn, which
+// updates that certificate in place on renewal instead of creating a new
+// one. It returns the certificate's ARN.
+func ImportToACM(region string, certPEM, privateKeyPEM, issuerPEM []byte, existingArn string) (string, error) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	client := acm.New(sess)
+
+	input := &acm.ImportCertificateInput{
+		Certificate:      certPEM,
+		PrivateKey:       privateKeyPEM,
+		CertificateChain: issuerPEM,
+	}
+	if existingArn != "" {
+		input.CertificateArn = aws.String(existingArn)
+	}
+
+	out, err := client.ImportCertificate(input)
+	if err != nil {
+		return "", fmt.Errorf("import certificate to ACM: %w", err)
+	}
+	return aws.StringValue(out.CertificateArn), nil
+}
+
+// UploadToIAM uploads a certificate as an IAM server certificate called
+// name, under path (e.g. "/cloudfront/"). IAM server certificates can't be
+// updated in place, so on renewal -- where name already exists from the
+// previous issuance -- the old one is deleted and the new material
+// reuploaded under the same name instead of being silently skipped.
+func UploadToIAM(region, name, path string, certPEM, privateKeyPEM, issuerPEM []byte) error {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	client := iam.New(sess)
+
+	input := &iam.UploadServerCertificateInput{
+		ServerCertificateName: aws.String(name),
+		CertificateBody:       aws.String(string(certPEM)),
+		CertificateChain:      aws.String(string(issuerPEM)),
+		PrivateKey:            aws.String(string(privateKeyPEM)),
+		Path:                  aws.String(path),
+	}
+
+	_, err := client.UploadServerCertificate(input)
+	if err == nil {
+		return nil
+	}
+
+	awsErr, ok := err.(awserr.Error)
+	if !ok || awsErr.Code() != iam.ErrCodeEntityAlreadyExistsException {
+		return fmt.Errorf("upload server certificate %s: %w", name, err)
+	}
+
+	if _, err := client.DeleteServerCertificate(&iam.DeleteServerCertificateInput{
+		ServerCertificateName: aws.String(name),
+	}); err != nil {
+		return fmt.Errorf("delete existing server certificate %s for renewal: %w", name, err)
+	}
+	if _, err := client.UploadServerCertificate(input); err != nil {
+		return fmt.Errorf("reupload server certificate %s after renewal: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Package certmgr tracks the lifecycle of ACME certificate sets issued by
+// the Lambda: it persists the material returned by lego alongside enough
+// metadata to decide, on a later invocation, whether the set is due for
+// renewal and to renew it in place instead of obtaining a fresh certificate.
+package certmgr
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage"
+)
+
+// DefaultRenewalWindow is how far ahead of expiry a certificate set is
+// renewed when SSM_RENEWAL_DAYS is not configured.
+const DefaultRenewalWindow = 30 * 24 * time.Hour
+
+// SavedCertificate is the JSON metadata blob persisted alongside the PEM
+// files for one certificate set, mirroring the fields of
+// certificate.Resource plus the leaf's expiry so a renewal decision doesn't
+// require re-fetching or re-parsing the certificate every time.
+type SavedCertificate struct {
+	Domains           []string  `json:"domains"`
+	CA                string    `json:"ca"`
+	CertURL           string    `json:"certUrl"`
+	CertStableURL     string    `json:"certStableUrl"`
+	NotAfter          time.Time `json:"notAfter"`
+	PrivateKey        []byte    `json:"privateKey"`
+	Certificate       []byte    `json:"certificate"`
+	IssuerCertificate []byte    `json:"issuerCertificate"`
+	CSR               []byte    `json:"csr"`
+
+	// CertificateArn is the ACM certificate ARN this set was last imported
+	// to, if ACM_IMPORT is enabled. It is reused on renewal so the
+	// certificate is updated in place instead of importing a new one.
+	CertificateArn string `json:"certificateArn,omitempty"`
+
+	// ACMDeployed and IAMDeployed record whether this exact certificate has
+	// already been successfully deployed to each optional target,
+	// independent of NeedsRenewal: a transient ACM/IAM failure leaves the
+	// corresponding flag false so the deploy is retried on the next
+	// invocation instead of waiting for the certificate's own renewal.
+	ACMDeployed bool `json:"acmDeployed,omitempty"`
+	IAMDeployed bool `json:"iamDeployed,omitempty"`
+}
+
+// Manager loads and saves SavedCertificate metadata for one or more
+// independent certificate sets via a storage.Storage backend.
+type Manager struct {
+	store storage.Storage
+}
+
+// New creates a Manager backed by store.
+func New(store storage.Storage) *Manager {
+	return &Manager{store: store}
+}
+
+// metadataKey returns the storage key for a certificate set's metadata
+// blob, namespaced by the set's first domain so multiple SAN groups can
+// coexist in one backend.
+func metadataKey(domains []string) string {
+	return "certificates/" + domains[0] + "/metadata.json"
+}
+
+// Load returns the previously saved metadata for domains. It returns
+// storage.ErrNotFound if nothing has been saved yet. Any other error
+// (throttling, permission denial, a network blip) is returned as-is rather
+// than treated the same way: callers must not mistake a transient read
+// failure for "never issued" and force a fresh ACME issuance, which would
+// burn rate limit on a certificate that was never actually due for renewal.
+func (m *Manager) Load(domains []string) (*SavedCertificate, error) {
+	data, err := m.store.GetCertificate(metadataKey(domains))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("load certificate metadata for %v: %w", domains, err)
+	}
+
+	var saved SavedCertificate
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("unmarshal certificate metadata: %w", err)
+	}
+	return &saved, nil
+}
+
+// Save persists metadata for a certificate set.
+func (m *Manager) Save(domains []string, saved *SavedCertificate) error {
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("marshal certificate metadata: %w", err)
+	}
+	return m.store.PutCertificate(metadataKey(domains), data)
+}
+
+// NeedsRenewal reports whether saved is due for renewal: it is nil (never
+// issued), was issued for a different domain set or against a different CA
+// than requested (e.g. SSM_LETSENCRYPT_DOMAINS or SSM_ACME_CA was changed),
+// or its leaf expires within window of now.
+func NeedsRenewal(saved *SavedCertificate, domains []string, ca string, window time.Duration, now time.Time) bool {
+	if saved == nil {
+		return true
+	}
+	if !domainsEqual(saved.Domains, domains) {
+		return true
+	}
+	if saved.CA != ca {
+		return true
+	}
+	return saved.NotAfter.Sub(now) < window
+}
+
+// domainsEqual reports whether a and b list the same domains in the same
+// order, matching how domains are compared against the metadata key
+// (domains[0]) and stored in SavedCertificate.Domains.
+func domainsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLeaf parses the first certificate in a PEM bundle, as returned by
+// lego with the issued certificate first.
+func ParseLeaf(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse leaf certificate: %w", err)
+	}
+	return leaf, nil
+}
@@ -0,0 +1,109 @@
+package certmgr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := 30 * 24 * time.Hour
+
+	tests := []struct {
+		name    string
+		saved   *SavedCertificate
+		domains []string
+		ca      string
+		want    bool
+	}{
+		{
+			name:    "never issued",
+			saved:   nil,
+			domains: []string{"example.com"},
+			ca:      "letsencrypt",
+			want:    true,
+		},
+		{
+			name: "well outside renewal window",
+			saved: &SavedCertificate{
+				Domains:  []string{"example.com"},
+				CA:       "letsencrypt",
+				NotAfter: now.Add(60 * 24 * time.Hour),
+			},
+			domains: []string{"example.com"},
+			ca:      "letsencrypt",
+			want:    false,
+		},
+		{
+			name: "exactly on the renewal window boundary",
+			saved: &SavedCertificate{
+				Domains:  []string{"example.com"},
+				CA:       "letsencrypt",
+				NotAfter: now.Add(window),
+			},
+			domains: []string{"example.com"},
+			ca:      "letsencrypt",
+			want:    false,
+		},
+		{
+			name: "one second inside the renewal window",
+			saved: &SavedCertificate{
+				Domains:  []string{"example.com"},
+				CA:       "letsencrypt",
+				NotAfter: now.Add(window - time.Second),
+			},
+			domains: []string{"example.com"},
+			ca:      "letsencrypt",
+			want:    true,
+		},
+		{
+			name: "already expired",
+			saved: &SavedCertificate{
+				Domains:  []string{"example.com"},
+				CA:       "letsencrypt",
+				NotAfter: now.Add(-time.Hour),
+			},
+			domains: []string{"example.com"},
+			ca:      "letsencrypt",
+			want:    true,
+		},
+		{
+			name: "CA changed since the saved certificate was issued",
+			saved: &SavedCertificate{
+				Domains:  []string{"example.com"},
+				CA:       "letsencrypt-staging",
+				NotAfter: now.Add(60 * 24 * time.Hour),
+			},
+			domains: []string{"example.com"},
+			ca:      "letsencrypt",
+			want:    true,
+		},
+		{
+			name: "SAN added to an existing domain group",
+			saved: &SavedCertificate{
+				Domains:  []string{"example.com"},
+				CA:       "letsencrypt",
+				NotAfter: now.Add(60 * 24 * time.Hour),
+			},
+			domains: []string{"example.com", "www.example.com"},
+			ca:      "letsencrypt",
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NeedsRenewal(tt.saved, tt.domains, tt.ca, window, now); got != tt.want {
+				t.Errorf("NeedsRenewal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetadataKeyNamespacesByFirstDomain(t *testing.T) {
+	got := metadataKey([]string{"example.com", "www.example.com"})
+	want := "certificates/example.com/metadata.json"
+	if got != want {
+		t.Errorf("metadataKey() = %q, want %q", got, want)
+	}
+}
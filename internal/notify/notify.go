@@ -0,0 +1,119 @@
+// Package notify publishes structured events after a certificate obtain or
+// renewal attempt so other systems (ACM import, CDN bounces, chat alerts)
+// can react without polling the storage backend for changes.
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Event describes the outcome of a single certificate obtain/renewal
+// attempt.
+type Event struct {
+	Domains           []string  `json:"domains"`
+	CA                string    `json:"ca"`
+	NotBefore         time.Time `json:"notBefore,omitempty"`
+	NotAfter          time.Time `json:"notAfter,omitempty"`
+	S3Bucket          string    `json:"s3Bucket,omitempty"`
+	S3Keys            []string  `json:"s3Keys,omitempty"`
+	SerialHex         string    `json:"serialHex,omitempty"`
+	SHA256Fingerprint string    `json:"sha256Fingerprint,omitempty"`
+	Action            string    `json:"action"` // "issued", "renewed", or "failed"
+	Error             string    `json:"error,omitempty"`
+}
+
+// Publisher delivers Events to whatever's listening downstream.
+type Publisher interface {
+	Publish(event Event) error
+}
+
+// New builds a Publisher from the SNS topic ARN and/or SQS queue URL
+// configured for this invocation. When both are set, Publish sends to
+// both; when neither is set, New returns a no-op publisher so callers
+// don't need to special-case "notifications disabled".
+func New(region, snsTopicARN, sqsQueueURL string) Publisher {
+	var publishers []Publisher
+	if snsTopicARN != "" {
+		publishers = append(publishers, newSNSPublisher(region, snsTopicARN))
+	}
+	if sqsQueueURL != "" {
+		publishers = append(publishers, newSQSPublisher(region, sqsQueueURL))
+	}
+
+	switch len(publishers) {
+	case 0:
+		return noopPublisher{}
+	case 1:
+		return publishers[0]
+	default:
+		return multiPublisher(publishers)
+	}
+}
+
+type noopPublisher struct{}
+
+func (noopPublisher) Publish(Event) error { return nil }
+
+type multiPublisher []Publisher
+
+func (m multiPublisher) Publish(event Event) error {
+	for _, p := range m {
+		if err := p.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type snsPublisher struct {
+	client   *sns.SNS
+	topicARN string
+}
+
+func newSNSPublisher(region, topicARN string) *snsPublisher {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	return &snsPublisher{client: sns.New(sess), topicARN: topicARN}
+}
+
+func (p *snsPublisher) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	_, err = p.client.Publish(&sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Message:  aws.String(string(data)),
+	})
+	return err
+}
+
+type sqsPublisher struct {
+	client   *sqs.SQS
+	queueURL string
+}
+
+func newSQSPublisher(region, queueURL string) *sqsPublisher {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	return &sqsPublisher{client: sqs.New(sess), queueURL: queueURL}
+}
+
+func (p *sqsPublisher) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	_, err = p.client.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(p.queueURL),
+		MessageBody: aws.String(string(data)),
+	})
+	return err
+}
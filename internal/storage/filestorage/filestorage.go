@@ -0,0 +1,49 @@
+// Package filestorage implements storage.Storage on a local directory
+// tree, mirroring the key layout used by s3storage so the same keys
+// ("accounts/<ca-host>/...", "certificates/<domain>/...") work unmodified.
+// It exists so the tool can be run outside Lambda -- on a dev laptop or in
+// CI -- without touching AWS.
+package filestorage
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage"
+)
+
+// Storage persists blobs as files under root, similar in spirit to lego's
+// own ".lego" on-disk layout.
+type Storage struct {
+	root string
+}
+
+// New creates a Storage rooted at dir.
+func New(dir string) *Storage {
+	return &Storage{root: dir}
+}
+
+func (s *Storage) GetAccount(key string) ([]byte, error)        { return s.get(key) }
+func (s *Storage) PutAccount(key string, data []byte) error     { return s.put(key, data) }
+func (s *Storage) GetCertificate(key string) ([]byte, error)    { return s.get(key) }
+func (s *Storage) PutCertificate(key string, data []byte) error { return s.put(key, data) }
+func (s *Storage) PutFile(key string, data []byte) error        { return s.put(key, data) }
+
+func (s *Storage) get(key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.root, filepath.FromSlash(key)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *Storage) put(key string, data []byte) error {
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
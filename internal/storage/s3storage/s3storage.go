@@ -0,0 +1,56 @@
+// Package s3storage implements storage.Storage on top of a single S3
+// bucket. This is the backend used when running as a Lambda in AWS, and
+// preserves the object layout the tool has always used.
+package s3storage
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage"
+)
+
+// Storage persists blobs as objects in a single S3 bucket, keyed directly
+// by the logical key passed to each method.
+type Storage struct {
+	client *s3.S3
+	bucket string
+}
+
+// New creates a Storage backed by bucket in region.
+func New(bucket, region string) *Storage {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+	return &Storage{client: s3.New(sess), bucket: bucket}
+}
+
+func (s *Storage) GetAccount(key string) ([]byte, error)        { return s.get(key) }
+func (s *Storage) PutAccount(key string, data []byte) error     { return s.put(key, data) }
+func (s *Storage) GetCertificate(key string) ([]byte, error)    { return s.get(key) }
+func (s *Storage) PutCertificate(key string, data []byte) error { return s.put(key, data) }
+func (s *Storage) PutFile(key string, data []byte) error        { return s.put(key, data) }
+
+func (s *Storage) get(key string) ([]byte, error) {
+	obj, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket), Key: aws.String(key),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && (awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == "NotFound") {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	defer obj.Body.Close()
+	return io.ReadAll(obj.Body)
+}
+
+func (s *Storage) put(key string, data []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket), Key: aws.String(key), Body: bytes.NewReader(data),
+	})
+	return err
+}
@@ -0,0 +1,33 @@
+// Package storage defines the persistence backend used for ACME account
+// state, issued certificates, and the plain files uploaded alongside them.
+// Concrete implementations live in the s3storage, filestorage, and
+// vaultstorage subpackages; STORAGE_BACKEND selects between them at
+// startup so the same Lambda code can run against AWS, a local directory
+// tree, or a HashiCorp Vault KV store.
+package storage
+
+import "errors"
+
+// ErrNotFound is returned by GetAccount/GetCertificate when key has never
+// been written, as distinct from any other read failure (throttling,
+// permission denial, a network blip). Callers that use a missing key as a
+// signal to fall back to some default (e.g. certmgr treating "no saved
+// certificate" as "issue a new one") must check for this specifically,
+// rather than treating every error the same way, so transient backend
+// errors don't get silently papered over.
+var ErrNotFound = errors.New("storage: key not found")
+
+// Storage stores and retrieves the byte blobs this tool persists, keyed by
+// a logical path (e.g. "accounts/<ca-host>/acme_user_privkey.pem" or
+// "certificates/<domain>/metadata.json"). GetAccount/PutAccount and
+// GetCertificate/PutCertificate are split out, rather than a single
+// Get/Put pair, so backends that want different handling per kind (e.g.
+// separate Vault mounts, separate S3 prefixes with different lifecycle
+// rules) can do so without inspecting the key.
+type Storage interface {
+	GetAccount(key string) ([]byte, error)
+	PutAccount(key string, data []byte) error
+	GetCertificate(key string) ([]byte, error)
+	PutCertificate(key string, data []byte) error
+	PutFile(key string, data []byte) error
+}
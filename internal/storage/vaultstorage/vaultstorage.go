@@ -0,0 +1,62 @@
+// Package vaultstorage implements storage.Storage on top of a HashiCorp
+// Vault KV v2 mount, for teams that already centralize secrets in Vault
+// and would rather not stand up a separate S3 bucket for ACME state.
+package vaultstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage"
+)
+
+// Storage persists blobs as base64-encoded secrets under a KV v2 mount,
+// keyed by the same logical key used by the other backends.
+type Storage struct {
+	kv     *vaultapi.KVv2
+	prefix string
+}
+
+// New creates a Storage against the KV v2 engine mounted at mountPath,
+// authenticating via a Vault client configured from the environment
+// (VAULT_ADDR, VAULT_TOKEN, and friends). prefix is prepended to every key,
+// e.g. "acme-lambda/", to namespace this tool's secrets within the mount.
+func New(mountPath, prefix string) (*Storage, error) {
+	client, err := vaultapi.NewClient(vaultapi.DefaultConfig())
+	if err != nil {
+		return nil, fmt.Errorf("create vault client: %w", err)
+	}
+	return &Storage{kv: client.KVv2(mountPath), prefix: prefix}, nil
+}
+
+func (s *Storage) GetAccount(key string) ([]byte, error)        { return s.get(key) }
+func (s *Storage) PutAccount(key string, data []byte) error     { return s.put(key, data) }
+func (s *Storage) GetCertificate(key string) ([]byte, error)    { return s.get(key) }
+func (s *Storage) PutCertificate(key string, data []byte) error { return s.put(key, data) }
+func (s *Storage) PutFile(key string, data []byte) error        { return s.put(key, data) }
+
+func (s *Storage) get(key string) ([]byte, error) {
+	secret, err := s.kv.Get(context.Background(), s.prefix+key)
+	if err != nil {
+		if errors.Is(err, vaultapi.ErrSecretNotFound) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	encoded, ok := secret.Data["data"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault secret %s missing \"data\" field", key)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func (s *Storage) put(key string, data []byte) error {
+	_, err := s.kv.Put(context.Background(), s.prefix+key, map[string]interface{}{
+		"data": base64.StdEncoding.EncodeToString(data),
+	})
+	return err
+}
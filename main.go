@@ -2,26 +2,21 @@ package main
 
 // CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -ldflags '-extldflags "-static"' -o bootstrap .
 import (
-	"bytes"
 	"context"
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/x509"
+	"crypto/sha256"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/go-acme/lego/v4/certcrypto"
@@ -29,12 +24,23 @@ import (
 	"github.com/go-acme/lego/v4/lego"
 	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
 	"github.com/go-acme/lego/v4/registration"
+
+	"github.com/dxas90/cloudflare-dns-s3/internal/acmdeploy"
+	"github.com/dxas90/cloudflare-dns-s3/internal/certmgr"
+	"github.com/dxas90/cloudflare-dns-s3/internal/notify"
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage"
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage/filestorage"
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage/s3storage"
+	"github.com/dxas90/cloudflare-dns-s3/internal/storage/vaultstorage"
 )
 
 type AcmeUser struct {
 	Email        string
 	Registration *registration.Resource
 	Key          crypto.PrivateKey
+	CA           string
+	EabKid       string
+	Type         string
 }
 
 var (
@@ -49,26 +55,86 @@ func (u *AcmeUser) GetPrivateKey() crypto.PrivateKey        { return u.Key }
 const (
 	privateKeyFile   = "acme_user_privkey.pem"
 	registrationFile = "acme_user_registration.json"
+
+	// defaultACMECA is used when SSM_ACME_CA is not set, preserving the
+	// previous default of issuing against the Let's Encrypt staging directory.
+	defaultACMECA = "letsencrypt-staging"
 )
 
-func createUser(email string) (*AcmeUser, error) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// acmeCA describes a selectable ACME certificate authority: the directory
+// URL lego talks to, and the host used to namespace per-CA account state
+// in S3 so switching CAs never clobbers an existing account.
+type acmeCA struct {
+	dirURL string
+	host   string
+}
+
+var acmeCAs = map[string]acmeCA{
+	"letsencrypt":         {dirURL: lego.LEDirectoryProduction, host: "acme-v02.api.letsencrypt.org"},
+	"letsencrypt-staging": {dirURL: lego.LEDirectoryStaging, host: "acme-staging-v02.api.letsencrypt.org"},
+	"zerossl":             {dirURL: "https://acme.zerossl.com/v2/DV90", host: "acme.zerossl.com"},
+	"buypass":             {dirURL: "https://api.buypass.com/acme/directory", host: "api.buypass.com"},
+	"google":              {dirURL: "https://dv.acme-v02.api.pki.goog/directory", host: "dv.acme-v02.api.pki.goog"},
+}
+
+// accountKeys returns the per-CA storage keys for the account private key
+// and registration blob, so accounts for different CAs live side by side.
+func accountKeys(ca string) (privKeyKey, registrationKey string) {
+	prefix := "accounts/" + acmeCAs[ca].host + "/"
+	return prefix + privateKeyFile, prefix + registrationFile
+}
+
+// accountEnvelope is the JSON blob persisted to storage, wrapping the lego
+// registration resource with the CA it was registered against and, when
+// External Account Binding was used, the EAB key ID.
+type accountEnvelope struct {
+	Registration *registration.Resource `json:"registration"`
+	CA           string                 `json:"ca"`
+	EabKid       string                 `json:"eabKid,omitempty"`
+	Type         string                 `json:"type,omitempty"`
+}
+
+// keyTypes maps the SSM_ACCOUNT_KEY_TYPE / SSM_CERT_KEY_TYPE values this
+// Lambda accepts to the certcrypto.KeyType lego expects.
+var keyTypes = map[string]certcrypto.KeyType{
+	"rsa2048": certcrypto.RSA2048,
+	"rsa3072": certcrypto.RSA3072,
+	"rsa4096": certcrypto.RSA4096,
+	"ec256":   certcrypto.EC256,
+	"ec384":   certcrypto.EC384,
+}
+
+// parseKeyType resolves one of the keyTypes values, defaulting to
+// defaultValue when s is empty.
+func parseKeyType(s, defaultValue string) (certcrypto.KeyType, error) {
+	if s == "" {
+		s = defaultValue
+	}
+	keyType, ok := keyTypes[s]
+	if !ok {
+		return "", fmt.Errorf("unsupported key type %q", s)
+	}
+	return keyType, nil
+}
+
+func createUser(email, ca string, accountKeyType certcrypto.KeyType) (*AcmeUser, error) {
+	privateKey, err := certcrypto.GeneratePrivateKey(accountKeyType)
 	if err != nil {
 		return nil, fmt.Errorf("generate key: %w", err)
 	}
-	return &AcmeUser{Email: email, Key: privateKey}, nil
+	return &AcmeUser{Email: email, Key: privateKey, CA: ca}, nil
 }
 
-func createClient(user *AcmeUser) (*lego.Client, error) {
-	config := lego.NewConfig(user)
-	config.Certificate.KeyType = certcrypto.RSA2048
-	useProduction := os.Getenv("USE_PRODUCTION_CA") == "true"
-	if useProduction {
-		config.CADirURL = lego.LEDirectoryProduction
-	} else {
-		config.CADirURL = lego.LEDirectoryStaging
+func createClient(user *AcmeUser, certKeyType certcrypto.KeyType) (*lego.Client, error) {
+	caCfg, ok := acmeCAs[user.CA]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ACME CA %q", user.CA)
 	}
 
+	config := lego.NewConfig(user)
+	config.Certificate.KeyType = certKeyType
+	config.CADirURL = caCfg.dirURL
+
 	client, err := lego.NewClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("create lego client: %w", err)
@@ -88,129 +154,253 @@ func createClient(user *AcmeUser) (*lego.Client, error) {
 	return client, nil
 }
 
-func obtainAndUploadCertificates(client *lego.Client, domains []string, s3Bucket, region string) error {
-	request := certificate.ObtainRequest{Domains: domains, Bundle: true}
+// certSetConfig bundles the per-invocation settings manageCertificateSet
+// needs beyond the certificate material itself. It has grown with each
+// delivery target this tool supports (storage, notify, ACM/IAM) enough
+// that threading them as individual parameters stopped being readable.
+type certSetConfig struct {
+	ca            string
+	region        string
+	s3Bucket      string
+	acmImport     bool
+	iamUploadPath string
+	notifier      notify.Publisher
+}
 
-	certs, err := client.Certificate.Obtain(request)
-	if err != nil {
-		return fmt.Errorf("obtain certificate: %w", err)
+// manageCertificateSet obtains or renews the certificate for domains,
+// consulting certMgr to decide which: a fresh Obtain when no certificate
+// has been saved yet, or a Renew reusing the saved certificate.Resource
+// when the saved leaf expires within renewalWindow. The result (material
+// and metadata) is persisted back to store either way, optionally deployed
+// to ACM/IAM, and a notify.Event is published on both success and failure
+// so downstream systems don't have to poll storage for changes.
+func manageCertificateSet(client *lego.Client, certMgr *certmgr.Manager, store storage.Storage, cfg certSetConfig, domains []string, renewalWindow time.Duration) error {
+	fail := func(err error) error {
+		cfg.notifier.Publish(notify.Event{Domains: domains, CA: cfg.ca, Action: "failed", Error: err.Error()})
+		return err
 	}
 
-	files := map[string][]byte{
-		"cert.pem":      certs.Certificate,
-		"fullchain.pem": certs.Certificate,
-		"privkey.pem":   certs.PrivateKey,
+	saved, loadErr := certMgr.Load(domains)
+	if loadErr != nil {
+		if !errors.Is(loadErr, storage.ErrNotFound) {
+			return fail(fmt.Errorf("load saved certificate for %v: %w", domains, loadErr))
+		}
+		log.Printf("No saved certificate for %v, issuing new one", domains)
+		saved = nil
 	}
 
-	for filename, data := range files {
-		certPath := "/tmp/" + filename
-		if err := os.WriteFile(certPath, data, 0600); err != nil {
-			return fmt.Errorf("write file %s: %w", certPath, err)
+	if saved != nil && !certmgr.NeedsRenewal(saved, domains, cfg.ca, renewalWindow, time.Now()) {
+		if !deployPending(cfg, saved) {
+			log.Printf("Certificate for %v not due for renewal until %s", domains, saved.NotAfter)
+			return nil
+		}
+		log.Printf("Certificate for %v not due for renewal, retrying pending deploy", domains)
+		if err := deployCertificate(cfg, certMgr, domains, saved); err != nil {
+			return fail(fmt.Errorf("deploy certificate for %v: %w", domains, err))
 		}
-		uploadFileToS3(s3Bucket, region, certPath)
+		return nil
 	}
-	return nil
-}
-
-func loadUserFromS3(bucket, region string) (*AcmeUser, error) {
-	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
-	s3client := s3.New(sess)
 
-	privKeyObj, err := s3client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucket), Key: aws.String(privateKeyFile),
-	})
+	action := "renewed"
+	var certs *certificate.Resource
+	var certificateArn string
+	var err error
+	if saved == nil {
+		action = "issued"
+		certs, err = client.Certificate.Obtain(certificate.ObtainRequest{Domains: domains, Bundle: true})
+	} else {
+		certificateArn = saved.CertificateArn
+		certs, err = client.Certificate.Renew(certificate.Resource{
+			Domain:        domains[0],
+			CertURL:       saved.CertURL,
+			CertStableURL: saved.CertStableURL,
+			PrivateKey:    saved.PrivateKey,
+			Certificate:   saved.Certificate,
+		}, true, false, "")
+	}
 	if err != nil {
-		return nil, err
+		return fail(fmt.Errorf("%s certificate for %v: %w", action, domains, err))
 	}
-	defer privKeyObj.Body.Close()
 
-	keyData, err := io.ReadAll(privKeyObj.Body)
+	leaf, err := certmgr.ParseLeaf(certs.Certificate)
 	if err != nil {
-		return nil, fmt.Errorf("read private key: %w", err)
+		return fail(fmt.Errorf("parse issued certificate for %v: %w", domains, err))
 	}
 
-	block, _ := pem.Decode(keyData)
-	if block == nil || block.Type != "EC PRIVATE KEY" {
-		return nil, fmt.Errorf("invalid PEM private key")
+	newSaved := &certmgr.SavedCertificate{
+		Domains:           domains,
+		CA:                cfg.ca,
+		CertURL:           certs.CertURL,
+		CertStableURL:     certs.CertStableURL,
+		NotAfter:          leaf.NotAfter,
+		PrivateKey:        certs.PrivateKey,
+		Certificate:       certs.Certificate,
+		IssuerCertificate: certs.IssuerCertificate,
+		CSR:               certs.CSR,
+		CertificateArn:    certificateArn,
 	}
 
-	privKey, err := x509.ParseECPrivateKey(block.Bytes)
+	// Persist the obtained material before attempting the optional ACM/IAM
+	// deploy steps below: the certificate has already been consumed from the
+	// ACME server, so a deploy failure must not strand it un-saved, or the
+	// next invocation would see the stale saved cert isn't due yet and never
+	// retry the deploy.
+	if err := certMgr.Save(domains, newSaved); err != nil {
+		return fail(fmt.Errorf("save certificate metadata for %v: %w", domains, err))
+	}
+
+	s3Keys, err := uploadCertificateFiles(domains, certs, store)
 	if err != nil {
-		return nil, fmt.Errorf("parse private key: %w", err)
+		return fail(err)
+	}
+
+	if err := deployCertificate(cfg, certMgr, domains, newSaved); err != nil {
+		return fail(fmt.Errorf("deploy certificate for %v: %w", domains, err))
 	}
 
-	regObj, err := s3client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucket), Key: aws.String(registrationFile),
+	cfg.notifier.Publish(notify.Event{
+		Domains:           domains,
+		CA:                cfg.ca,
+		NotBefore:         leaf.NotBefore,
+		NotAfter:          leaf.NotAfter,
+		S3Bucket:          cfg.s3Bucket,
+		S3Keys:            s3Keys,
+		SerialHex:         leaf.SerialNumber.Text(16),
+		SHA256Fingerprint: fmt.Sprintf("%x", sha256.Sum256(leaf.Raw)),
+		Action:            action,
 	})
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+// iamCertificateName derives an IAM-safe server certificate name from a
+// domain: IAM's name charset excludes "*", the one character a wildcard
+// domain would otherwise contain.
+func iamCertificateName(domain string) string {
+	return strings.ReplaceAll(domain, "*", "wildcard")
+}
+
+// deployPending reports whether cfg enables a deploy target that saved
+// hasn't been successfully deployed to yet, so an otherwise-current
+// certificate still needs deployCertificate run again.
+func deployPending(cfg certSetConfig, saved *certmgr.SavedCertificate) bool {
+	if cfg.acmImport && !saved.ACMDeployed {
+		return true
+	}
+	if cfg.iamUploadPath != "" && !saved.IAMDeployed {
+		return true
 	}
-	defer regObj.Body.Close()
+	return false
+}
 
-	regData, err := io.ReadAll(regObj.Body)
-	if err != nil {
-		return nil, fmt.Errorf("read registration: %w", err)
+// deployCertificate imports saved's material into ACM and/or uploads it to
+// IAM per cfg, skipping any target already marked deployed, and persists
+// the updated deploy flags (and any new ACM ARN) after each target so a
+// failure partway through -- or a transient failure on a target that isn't
+// due for renewal at all -- is retried on the next invocation instead of
+// silently never running again.
+func deployCertificate(cfg certSetConfig, certMgr *certmgr.Manager, domains []string, saved *certmgr.SavedCertificate) error {
+	if cfg.acmImport && !saved.ACMDeployed {
+		arn, err := acmdeploy.ImportToACM(cfg.region, saved.Certificate, saved.PrivateKey, saved.IssuerCertificate, saved.CertificateArn)
+		if err != nil {
+			return err
+		}
+		saved.CertificateArn = arn
+		saved.ACMDeployed = true
+		if err := certMgr.Save(domains, saved); err != nil {
+			return err
+		}
 	}
+	if cfg.iamUploadPath != "" && !saved.IAMDeployed {
+		name := iamCertificateName(domains[0])
+		if err := acmdeploy.UploadToIAM(cfg.region, name, cfg.iamUploadPath, saved.Certificate, saved.PrivateKey, saved.IssuerCertificate); err != nil {
+			return err
+		}
+		saved.IAMDeployed = true
+		if err := certMgr.Save(domains, saved); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	var reg registration.Resource
-	if err := json.Unmarshal(regData, &reg); err != nil {
-		return nil, fmt.Errorf("unmarshal registration: %w", err)
+func uploadCertificateFiles(domains []string, certs *certificate.Resource, store storage.Storage) ([]string, error) {
+	prefix := "certificates/" + domains[0] + "/"
+
+	files := map[string][]byte{
+		"cert.pem":      certs.Certificate,
+		"fullchain.pem": certs.Certificate,
+		"privkey.pem":   certs.PrivateKey,
 	}
-	var email string
-	if len(reg.Body.Contact) > 0 {
-		email = reg.Body.Contact[0]
-	} else {
-		email = letsencryptEmail // or fallback to some default or error
+
+	var keys []string
+	for filename, data := range files {
+		key := prefix + filename
+		if err := store.PutFile(key, data); err != nil {
+			return nil, fmt.Errorf("upload %s: %w", key, err)
+		}
+		keys = append(keys, key)
 	}
-	return &AcmeUser{Email: email, Key: privKey, Registration: &reg}, nil
+	return keys, nil
 }
 
-func saveUserToS3(user *AcmeUser, bucket, region string) error {
-	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
-	s3client := s3.New(sess)
+func loadUser(store storage.Storage, ca string) (*AcmeUser, error) {
+	privKeyKey, registrationKey := accountKeys(ca)
 
-	privKeyBytes, err := x509.MarshalECPrivateKey(user.Key.(*ecdsa.PrivateKey))
+	keyData, err := store.GetAccount(privKeyKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	privKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privKeyBytes})
 
-	_, err = s3client.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucket), Key: aws.String(privateKeyFile), Body: bytes.NewReader(privKeyPEM),
-	})
+	privKey, err := certcrypto.ParsePEMPrivateKey(keyData)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("parse private key: %w", err)
 	}
 
-	regData, err := json.Marshal(user.Registration)
+	regData, err := store.GetAccount(registrationKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = s3client.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucket), Key: aws.String(registrationFile), Body: bytes.NewReader(regData),
-	})
-	return err
+	var envelope accountEnvelope
+	if err := json.Unmarshal(regData, &envelope); err != nil {
+		return nil, fmt.Errorf("unmarshal registration: %w", err)
+	}
+	var email string
+	if envelope.Registration != nil && len(envelope.Registration.Body.Contact) > 0 {
+		email = envelope.Registration.Body.Contact[0]
+	} else {
+		email = letsencryptEmail // or fallback to some default or error
+	}
+	return &AcmeUser{
+		Email:        email,
+		Key:          privKey,
+		Registration: envelope.Registration,
+		CA:           ca,
+		EabKid:       envelope.EabKid,
+		Type:         envelope.Type,
+	}, nil
 }
 
-func uploadFileToS3(bucket, region, s3filepath string) {
-	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
-	s3client := s3.New(sess)
+func saveUser(store storage.Storage, user *AcmeUser) error {
+	privKeyKey, registrationKey := accountKeys(user.CA)
 
-	file, err := os.Open(s3filepath)
-	if err != nil {
-		log.Fatalf("open file %s: %v", s3filepath, err)
+	privKeyPEM := certcrypto.PEMEncode(user.Key)
+
+	if err := store.PutAccount(privKeyKey, privKeyPEM); err != nil {
+		return err
 	}
-	defer file.Close()
 
-	key := filepath.Base(s3filepath)
-	_, err = s3client.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(bucket), Key: aws.String(key), Body: file,
+	regData, err := json.Marshal(accountEnvelope{
+		Registration: user.Registration,
+		CA:           user.CA,
+		EabKid:       user.EabKid,
+		Type:         user.Type,
 	})
 	if err != nil {
-		log.Fatalf("upload %s to S3: %v", key, err)
+		return err
 	}
-	log.Printf("Uploaded %s to S3 bucket %s", key, bucket)
+
+	return store.PutAccount(registrationKey, regData)
 }
 
 func getSecretValue(client *secretsmanager.SecretsManager, secretName string) string {
@@ -241,14 +431,35 @@ func getSSMParameter(client *ssm.SSM, name string) string {
 	return aws.StringValue(param.Parameter.Value)
 }
 
+// getOptionalEnvParam resolves envVar to an SSM parameter path and fetches
+// it, falling back to defaultValue when envVar itself is unset.
+func getOptionalEnvParam(client *ssm.SSM, envVar, defaultValue string) string {
+	path := os.Getenv(envVar)
+	if path == "" {
+		return defaultValue
+	}
+	return getSSMParameter(client, path)
+}
+
+// getOptionalSecretEnvParam resolves envVar to a Secrets Manager secret name
+// and fetches it, returning "" when envVar itself is unset.
+func getOptionalSecretEnvParam(client *secretsmanager.SecretsManager, envVar string) string {
+	path := os.Getenv(envVar)
+	if path == "" {
+		return ""
+	}
+	return getSecretValue(client, path)
+}
+
 func handleRequest(ctx context.Context) {
 	region := os.Getenv("AWS_REGION")
 	if region == "" {
 		region = "us-east-1"
 	}
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("Missing S3_BUCKET environment variable")
+
+	store, err := newStorageBackend(region)
+	if err != nil {
+		log.Fatalf("storage backend: %v", err)
 	}
 
 	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
@@ -275,44 +486,173 @@ func handleRequest(ctx context.Context) {
 	zoneID := getEnvParam("SSM_CLOUDFLARE_ZONE_ID")
 	letsencryptEmail = getEnvParam("SSM_LETSENCRYPT_EMAIL")
 	domainCSV := getEnvParam("SSM_LETSENCRYPT_DOMAINS")
-	domains := strings.Split(domainCSV, ",")
+	domainGroups := parseDomainGroups(domainCSV)
+
+	caName := getOptionalEnvParam(ssmClient, "SSM_ACME_CA", defaultACMECA)
+	if _, ok := acmeCAs[caName]; !ok {
+		log.Fatalf("Unsupported SSM_ACME_CA value %q", caName)
+	}
+	eabKid := getOptionalSecretEnvParam(smClient, "SM_ACME_EAB_KID")
+	eabHMAC := getOptionalSecretEnvParam(smClient, "SM_ACME_EAB_HMAC")
+
+	accountKeyType, err := parseKeyType(getOptionalEnvParam(ssmClient, "SSM_ACCOUNT_KEY_TYPE", ""), "ec256")
+	if err != nil {
+		log.Fatalf("SSM_ACCOUNT_KEY_TYPE: %v", err)
+	}
+	certKeyType, err := parseKeyType(getOptionalEnvParam(ssmClient, "SSM_CERT_KEY_TYPE", ""), "rsa2048")
+	if err != nil {
+		log.Fatalf("SSM_CERT_KEY_TYPE: %v", err)
+	}
+
+	renewalWindow := renewalWindowFromEnv(ssmClient)
+	certMgr := certmgr.New(store)
+	notifier := notify.New(region, os.Getenv("SNS_TOPIC_ARN"), os.Getenv("SQS_QUEUE_URL"))
+	certCfg := certSetConfig{
+		ca:            caName,
+		region:        region,
+		s3Bucket:      os.Getenv("S3_BUCKET"),
+		acmImport:     os.Getenv("ACM_IMPORT") == "true",
+		iamUploadPath: os.Getenv("IAM_UPLOAD_PATH"),
+		notifier:      notifier,
+	}
 
 	os.Setenv("CLOUDFLARE_DNS_API_TOKEN", cfapiToken)
 	os.Setenv("CLOUDFLARE_ZONE_ID", zoneID)
 	os.Setenv("CLOUDFLARE_EMAIL", cloudflareEmail)
 
-	user, err := loadUserFromS3(s3Bucket, region)
+	var failed bool
+	user, err := loadUser(store, caName)
 	if err != nil {
-		log.Println("User not found in S3, creating new one...")
-		user, err = createUser(letsencryptEmail)
+		log.Println("User not found in storage, creating new one...")
+		user, err = createUser(letsencryptEmail, caName, accountKeyType)
 		if err != nil {
-			log.Fatalf("create user: %v", err)
+			notifyFatal(notifier, caName, "create user", err)
 		}
-		client, err := createClient(user)
+		client, err := createClient(user, certKeyType)
 		if err != nil {
-			log.Fatalf("lego client: %v", err)
+			notifyFatal(notifier, caName, "lego client", err)
+		}
+		var reg *registration.Resource
+		if eabKid != "" && eabHMAC != "" {
+			reg, err = client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+				TermsOfServiceAgreed: true,
+				Kid:                  eabKid,
+				HmacEncoded:          eabHMAC,
+			})
+			user.EabKid = eabKid
+			user.Type = "eab"
+		} else {
+			reg, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+			user.Type = "acme"
 		}
-		reg, err := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
 		if err != nil {
-			log.Fatalf("register user: %v", err)
+			notifyFatal(notifier, caName, "register user", err)
 		}
 		user.Registration = reg
-		if err := saveUserToS3(user, s3Bucket, region); err != nil {
-			log.Fatalf("save user: %v", err)
-		}
-		if err := obtainAndUploadCertificates(client, domains, s3Bucket, region); err != nil {
-			log.Fatalf("certificates: %v", err)
+		if err := saveUser(store, user); err != nil {
+			notifyFatal(notifier, caName, "save user", err)
 		}
+		failed = processDomainGroups(client, certMgr, store, certCfg, domainGroups, renewalWindow)
 	} else {
-		log.Println("User loaded from S3")
-		client, err := createClient(user)
+		log.Println("User loaded from storage")
+		client, err := createClient(user, certKeyType)
 		if err != nil {
-			log.Fatalf("lego client: %v", err)
+			notifyFatal(notifier, caName, "lego client", err)
+		}
+		failed = processDomainGroups(client, certMgr, store, certCfg, domainGroups, renewalWindow)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// processDomainGroups runs manageCertificateSet for every independent SAN
+// group, logging a failure rather than aborting -- each is already reported
+// via notify inside manageCertificateSet -- so one bad domain group doesn't
+// block renewal of unrelated certificate sets in the same invocation. It
+// reports whether any group failed, so the caller can still exit non-zero
+// once every group has been attempted.
+func processDomainGroups(client *lego.Client, certMgr *certmgr.Manager, store storage.Storage, cfg certSetConfig, domainGroups [][]string, renewalWindow time.Duration) bool {
+	failed := false
+	for _, domains := range domainGroups {
+		if err := manageCertificateSet(client, certMgr, store, cfg, domains, renewalWindow); err != nil {
+			log.Printf("certificates %v: %v", domains, err)
+			failed = true
 		}
-		if err := obtainAndUploadCertificates(client, domains, s3Bucket, region); err != nil {
-			log.Fatalf("certificates: %v", err)
+	}
+	return failed
+}
+
+// notifyFatal publishes a "failed" notify.Event describing context and err
+// before exiting, so the top-level setup failures that previously just
+// logged and died are visible to anything subscribed via notify.
+func notifyFatal(notifier notify.Publisher, ca, context string, err error) {
+	notifier.Publish(notify.Event{CA: ca, Action: "failed", Error: fmt.Sprintf("%s: %v", context, err)})
+	log.Fatalf("%s: %v", context, err)
+}
+
+// parseDomainGroups parses SSM_LETSENCRYPT_DOMAINS into independent SAN
+// sets: ";" separates certificate groups, "," separates the domains within
+// a group, and whitespace around either is trimmed.
+func parseDomainGroups(domainCSV string) [][]string {
+	var groups [][]string
+	for _, group := range strings.Split(domainCSV, ";") {
+		var domains []string
+		for _, domain := range strings.Split(group, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
 		}
+		if len(domains) > 0 {
+			groups = append(groups, domains)
+		}
+	}
+	return groups
+}
+
+// newStorageBackend selects and constructs the storage.Storage backend
+// named by STORAGE_BACKEND (default "s3"): "s3" reads S3_BUCKET, "file"
+// reads STORAGE_FILE_DIR, and "vault" reads VAULT_KV_MOUNT and
+// VAULT_KV_PREFIX (Vault's own client config is read from VAULT_ADDR /
+// VAULT_TOKEN by the Vault SDK).
+func newStorageBackend(region string) (storage.Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "s3":
+		bucket := os.Getenv("S3_BUCKET")
+		if bucket == "" {
+			return nil, fmt.Errorf("missing S3_BUCKET environment variable")
+		}
+		return s3storage.New(bucket, region), nil
+	case "file":
+		dir := os.Getenv("STORAGE_FILE_DIR")
+		if dir == "" {
+			return nil, fmt.Errorf("missing STORAGE_FILE_DIR environment variable")
+		}
+		return filestorage.New(dir), nil
+	case "vault":
+		mount := os.Getenv("VAULT_KV_MOUNT")
+		if mount == "" {
+			return nil, fmt.Errorf("missing VAULT_KV_MOUNT environment variable")
+		}
+		return vaultstorage.New(mount, os.Getenv("VAULT_KV_PREFIX"))
+	default:
+		return nil, fmt.Errorf("unsupported STORAGE_BACKEND %q", backend)
+	}
+}
+
+// renewalWindowFromEnv resolves SSM_RENEWAL_DAYS, falling back to
+// certmgr.DefaultRenewalWindow when it is not configured.
+func renewalWindowFromEnv(ssmClient *ssm.SSM) time.Duration {
+	days := getOptionalEnvParam(ssmClient, "SSM_RENEWAL_DAYS", "")
+	if days == "" {
+		return certmgr.DefaultRenewalWindow
+	}
+	n, err := strconv.Atoi(days)
+	if err != nil {
+		log.Fatalf("invalid SSM_RENEWAL_DAYS value %q: %v", days, err)
 	}
+	return time.Duration(n) * 24 * time.Hour
 }
 
 func main() {